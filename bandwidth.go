@@ -0,0 +1,166 @@
+// bandwidth.go - io.Reader/io.Writer wrappers driven by a Limiter
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// chunk is the largest number of bytes treated as a single batch of
+// tokens per Read/Write. batchSize further caps this to the relevant
+// bucket's burst, so a single call never asks the underlying Limiter to
+// wait for more tokens than its burst can hold.
+const chunk = 32 * 1024
+
+// Reader wraps an io.Reader so that the rate of bytes read from it is
+// capped by a Limiter, treating each byte as one token. Use NewReader to
+// draw from the Limiter's global bucket, or NewHostReader to draw from
+// one host's per-host bucket instead.
+type Reader struct {
+	r io.Reader
+	l *Limiter
+	a net.Addr // nil => global bucket
+}
+
+// NewReader wraps 'r', blocking reads as needed so the rate of bytes
+// read does not exceed 'l's configured global rate.
+func NewReader(r io.Reader, l *Limiter) *Reader {
+	return &Reader{r: r, l: l}
+}
+
+// NewHostReader wraps 'r', blocking reads as needed so the rate of bytes
+// read does not exceed 'l's configured per-host rate for 'a'.
+func NewHostReader(r io.Reader, l *Limiter, a net.Addr) *Reader {
+	return &Reader{r: r, l: l, a: a}
+}
+
+// Read implements io.Reader.
+func (z *Reader) Read(p []byte) (int, error) {
+	if max := batchSize(z.l, z.a); len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := z.r.Read(p)
+	if n > 0 {
+		if werr := z.wait(n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// SetLimit dynamically retunes the bandwidth cap to 'bytesPerSec'.
+func (z *Reader) SetLimit(bytesPerSec int) {
+	setLimit(z.l, z.a, bytesPerSec)
+}
+
+func (z *Reader) wait(n int) error {
+	return waitN(z.l, z.a, n)
+}
+
+// Writer wraps an io.Writer so that the rate of bytes written to it is
+// capped by a Limiter, treating each byte as one token. Use NewWriter to
+// draw from the Limiter's global bucket, or NewHostWriter to draw from
+// one host's per-host bucket instead.
+type Writer struct {
+	w io.Writer
+	l *Limiter
+	a net.Addr // nil => global bucket
+}
+
+// NewWriter wraps 'w', blocking writes as needed so the rate of bytes
+// written does not exceed 'l's configured global rate.
+func NewWriter(w io.Writer, l *Limiter) *Writer {
+	return &Writer{w: w, l: l}
+}
+
+// NewHostWriter wraps 'w', blocking writes as needed so the rate of
+// bytes written does not exceed 'l's configured per-host rate for 'a'.
+func NewHostWriter(w io.Writer, l *Limiter, a net.Addr) *Writer {
+	return &Writer{w: w, l: l, a: a}
+}
+
+// Write implements io.Writer.
+func (z *Writer) Write(p []byte) (int, error) {
+	var total int
+
+	max := batchSize(z.l, z.a)
+	for len(p) > 0 {
+		b := p
+		if len(b) > max {
+			b = b[:max]
+		}
+
+		if err := z.wait(len(b)); err != nil {
+			return total, err
+		}
+
+		n, err := z.w.Write(b)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		p = p[len(b):]
+	}
+
+	return total, nil
+}
+
+// SetLimit dynamically retunes the bandwidth cap to 'bytesPerSec'.
+func (z *Writer) SetLimit(bytesPerSec int) {
+	setLimit(z.l, z.a, bytesPerSec)
+}
+
+func (z *Writer) wait(n int) error {
+	return waitN(z.l, z.a, n)
+}
+
+// batchSize returns the largest number of bytes a single Read/Write may
+// draw from l's global bucket (a == nil) or per-host bucket for 'a' in
+// one WaitN/WaitNHost call, so a chunk is never capped below 1 (when the
+// burst is misconfigured to 0) and never asks for more tokens than the
+// bucket can ever hold.
+func batchSize(l *Limiter, a net.Addr) int {
+	burst := l.Burst()
+	if a != nil {
+		burst = l.BurstHost(a)
+	}
+
+	max := chunk
+	if burst > 0 && burst < max {
+		max = burst
+	}
+	return max
+}
+
+// waitN blocks until 'l' admits 'n' bytes from its global bucket (a ==
+// nil) or the per-host bucket for 'a'.
+func waitN(l *Limiter, a net.Addr, n int) error {
+	ctx := context.Background()
+	if a == nil {
+		return l.WaitN(ctx, n)
+	}
+	return l.WaitNHost(ctx, a, n)
+}
+
+// setLimit retunes 'l's global rate (a == nil) or per-host rate to
+// 'bytesPerSec'.
+func setLimit(l *Limiter, a net.Addr, bytesPerSec int) {
+	if a == nil {
+		l.SetLimit(bytesPerSec)
+		return
+	}
+	l.SetLimitHost(bytesPerSec)
+}
+
+// EOF
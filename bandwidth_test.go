@@ -0,0 +1,120 @@
+// bandwidth_test.go -- Test harness for Reader/Writer
+//
+// License: GPLv2
+//
+
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReaderThrottles(t *testing.T) {
+	l, err := New(1000, 1000, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	data := bytes.Repeat([]byte("x"), 64)
+	src := bytes.NewReader(data)
+	r := NewReader(src, l)
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected read error: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestReaderRespectsBurstCap(t *testing.T) {
+	l, err := New(1000, 1000, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	// chunk (32KB) is bigger than this Limiter's global burst; a single
+	// Read must cap its batch to the burst instead of asking Wait for
+	// more tokens than the bucket can ever hold.
+	burst := l.Burst()
+	data := bytes.Repeat([]byte("z"), burst+200)
+	src := bytes.NewReader(data)
+	r := NewReader(src, l)
+
+	buf := make([]byte, len(data))
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected read error: %s", err)
+	}
+	if n <= 0 || n > burst {
+		t.Fatalf("expected a single Read to be capped at the burst size %d; got %d", burst, n)
+	}
+}
+
+func TestWriterThrottles(t *testing.T) {
+	l, err := New(1000, 1000, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	data := bytes.Repeat([]byte("y"), 64)
+	var dst bytes.Buffer
+	w := NewWriter(&dst, l)
+
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if n != len(data) {
+		t.Fatalf("expected to write %d bytes, wrote %d", len(data), n)
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Fatalf("data mismatch")
+	}
+}
+
+func TestWriterRespectsBurstCap(t *testing.T) {
+	l, err := New(1000, 1000, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	// Same as TestReaderRespectsBurstCap, but for Write's internal
+	// chunking loop: writing more than the burst in one call used to
+	// fail with "exceeds limiter's burst" because chunk (32KB) is
+	// bigger than the burst here.
+	burst := l.Burst()
+	data := bytes.Repeat([]byte("z"), burst+200)
+	var dst bytes.Buffer
+	w := NewWriter(&dst, l)
+
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+	if n != len(data) {
+		t.Fatalf("expected to write %d bytes, wrote %d", len(data), n)
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Fatalf("data mismatch")
+	}
+}
+
+func TestWriterSetLimit(t *testing.T) {
+	l, err := New(1000, 1000, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	w := NewWriter(io.Discard, l)
+	w.SetLimit(500)
+
+	if got := l.Limit(); got != 500 {
+		t.Fatalf("expected global limit to be retuned to 500; saw %v", got)
+	}
+}
+
+// vim: noexpandtab:ts=8:sw=8:tw=92:
@@ -0,0 +1,156 @@
+// composite.go - Tiered limiter combining multiple named scopes
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// Scope identifies one limiter to check as part of a CompositeLimiter.Allow
+// call: 'Name' selects a scope registered via Register (e.g. "global",
+// "route:/api/v1/upload", "method:POST"), and 'Key' selects which
+// instance of that scope to charge (e.g. a client IP or user ID; the
+// empty string for scopes that aren't keyed, such as a single global
+// limit).
+type Scope struct {
+	Name string
+	Key  string
+}
+
+// CompositeLimiter holds a set of named, independently configured
+// token-bucket limiters ("scopes"), each further split by an arbitrary
+// key. A single Allow call charges one event against every scope named
+// in it, checking them in the order they are passed; if any scope denies
+// the event, none of them are charged. This lets one CompositeLimiter replace several ad hoc
+// Limiters when different tiers (global, per-route, per-method, ...)
+// need independent budgets.
+type CompositeLimiter struct {
+	mu     sync.RWMutex
+	scopes map[string]*scopeLimiter
+}
+
+// scopeLimiter is one registered scope: a rate/burst configuration
+// shared by every key within it, each key getting its own *rate.Limiter.
+type scopeLimiter struct {
+	rate  rate.Limit
+	burst int
+	h     *lru.TwoQueueCache[string, *rate.Limiter]
+}
+
+// NewComposite creates an empty CompositeLimiter. Use Register to add
+// scopes before calling Allow.
+func NewComposite() *CompositeLimiter {
+	return &CompositeLimiter{
+		scopes: make(map[string]*scopeLimiter),
+	}
+}
+
+// Register adds (or replaces) a scope named 'name' that limits each of
+// its keys to 'r' events/sec with burst 'burst', remembering the
+// 'cachesize' most recently seen keys.
+func (c *CompositeLimiter) Register(name string, r, burst, cachesize int) error {
+	h, err := lru.New2Q[string, *rate.Limiter](cachesize)
+	if err != nil {
+		return fmt.Errorf("ratelimit: can't create LRU cache: %s", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scopes[name] = &scopeLimiter{
+		rate:  limit(r),
+		burst: burst,
+		h:     h,
+	}
+	return nil
+}
+
+// SetLimit retunes the scope named 'name' to 'r' events/sec with burst
+// 'burst', without restarting the process. This updates every key
+// already seen in the scope as well as any created afterwards.
+func (c *CompositeLimiter) SetLimit(name string, r, burst int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.scopes[name]
+	if !ok {
+		return fmt.Errorf("ratelimit: composite: no such scope %q", name)
+	}
+
+	s.rate = limit(r)
+	s.burst = burst
+
+	for _, k := range s.h.Keys() {
+		if rl, ok := s.h.Peek(k); ok {
+			rl.SetLimit(s.rate)
+			rl.SetBurst(burst)
+		}
+	}
+	return nil
+}
+
+// Allow charges one event against every scope in 'scopes' and reports
+// whether all of them admit it. It is all-or-nothing: if any scope
+// denies the event, none of the scopes named in 'scopes' are charged. A
+// Scope naming a scope that was never Register-ed is ignored (treated as
+// unlimited), so composite checks can be extended without touching every
+// call site.
+func (c *CompositeLimiter) Allow(scopes ...Scope) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+
+	type reservation struct {
+		res *rate.Reservation
+		at  time.Time
+	}
+	reserved := make([]reservation, 0, len(scopes))
+
+	for _, sc := range scopes {
+		s, ok := c.scopes[sc.Name]
+		if !ok {
+			continue
+		}
+
+		rl := s.getLimiter(sc.Key)
+		res := rl.ReserveN(now, 1)
+		if !res.OK() || res.DelayFrom(now) > 0 {
+			res.CancelAt(now)
+			for _, rv := range reserved {
+				rv.res.CancelAt(rv.at)
+			}
+			return false
+		}
+
+		reserved = append(reserved, reservation{res: res, at: now})
+	}
+
+	return true
+}
+
+// getLimiter returns the *rate.Limiter for 'key' within scope 's',
+// creating one if this is the first time 'key' is seen. This evicts the
+// least-recently-used key from the scope's LRU cache.
+func (s *scopeLimiter) getLimiter(key string) *rate.Limiter {
+	rl, ok := s.h.Get(key)
+	if !ok {
+		rl = rate.NewLimiter(s.rate, s.burst)
+		s.h.Add(key, rl)
+	}
+	return rl
+}
+
+// EOF
@@ -0,0 +1,81 @@
+// composite_test.go -- Test harness for CompositeLimiter
+//
+// License: GPLv2
+//
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompositeAllOrNothing(t *testing.T) {
+	c := NewComposite()
+
+	if err := c.Register("global", 1000, 2, 16); err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+	if err := c.Register("route:/upload", 1, 1, 16); err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	route := Scope{Name: "route:/upload", Key: "1.2.3.4"}
+	global := Scope{Name: "global", Key: ""}
+
+	if !c.Allow(global, route) {
+		t.Fatalf("expected 1st call to be allowed")
+	}
+
+	// route:/upload has burst 1, so the 2nd call should be denied even
+	// though "global" still has budget; and since it's all-or-nothing,
+	// global must not be charged either.
+	if c.Allow(global, route) {
+		t.Fatalf("expected 2nd call to be denied by the route scope")
+	}
+
+	// A request that only touches "global" should still succeed, proving
+	// the denied call above didn't charge the global scope.
+	if !c.Allow(global) {
+		t.Fatalf("expected global-only call to be allowed")
+	}
+}
+
+func TestCompositeUnknownScopeIgnored(t *testing.T) {
+	c := NewComposite()
+
+	if !c.Allow(Scope{Name: "nope", Key: "x"}) {
+		t.Fatalf("expected unregistered scope to be treated as unlimited")
+	}
+}
+
+func TestCompositeSetLimit(t *testing.T) {
+	c := NewComposite()
+
+	if err := c.Register("route:/upload", 5, 1, 16); err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	sc := Scope{Name: "route:/upload", Key: "1.2.3.4"}
+
+	if !c.Allow(sc) {
+		t.Fatalf("expected 1st call to be allowed")
+	}
+	if c.Allow(sc) {
+		t.Fatalf("expected 2nd call to be denied")
+	}
+
+	// Raise the rate enough that the bucket refills well within the
+	// sleep below, and confirm the already-seen key picks up the change.
+	if err := c.SetLimit("route:/upload", 1000, 1); err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if !c.Allow(sc) {
+		t.Fatalf("expected call after raising the rate to be allowed")
+	}
+}
+
+// vim: noexpandtab:ts=8:sw=8:tw=92:
@@ -0,0 +1,217 @@
+// failrate.go - Rate limiting keyed off failures, not all traffic
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// LimiterFailRate rate-limits based on the outcome of an operation
+// rather than its mere occurrence: a token is only permanently consumed
+// when the caller reports the operation failed. This is useful for
+// login/auth endpoints, where legitimate users should incur no cost but
+// an IP that keeps failing should be throttled progressively harder.
+//
+// Every Reserve/ReserveHost optimistically takes a token from a normal
+// token-bucket limiter and reports via the returned Reservation's
+// Allowed() (and RetryAfter(), when denied) whether the key is currently
+// within its rate limit. The caller must also report the outcome of the
+// operation via Success() (returns the token) or Failure() (keeps it,
+// and counts towards the per-key backoff below).
+//
+// After 'threshold' consecutive failures from the same key, that key's
+// rate is halved (down to a floor of 1 event/sec); it recovers
+// to the configured rate once 'quiet' elapses without a new failure.
+type LimiterFailRate struct {
+	g *failState
+
+	h *lru.TwoQueueCache[string, *failState]
+
+	rate, hrate rate.Limit
+	burst, hburst int
+
+	threshold int
+	quiet     time.Duration
+
+	cache int
+}
+
+// failState is the per-key (global or per-host) bookkeeping behind a
+// LimiterFailRate: the underlying token bucket plus the consecutive
+// failure count driving its backoff.
+type failState struct {
+	mu sync.Mutex
+
+	rl *rate.Limiter
+
+	base        rate.Limit
+	consecutive int
+	last        time.Time
+}
+
+// Reservation is a handle returned by Reserve/ReserveHost. Exactly one
+// of Success or Failure must be called to settle it.
+type Reservation struct {
+	res     *rate.Reservation
+	at      time.Time
+	fs      *failState
+	fr      *LimiterFailRate
+	allowed bool
+	delay   time.Duration
+}
+
+// Allowed reports whether the event was within the key's current rate
+// limit at the time of Reserve/ReserveHost. Callers that drop/reject
+// events exceeding the limit should check this (and RetryAfter) before
+// proceeding, the same way Limiter.Allow's callers do.
+func (rv *Reservation) Allowed() bool {
+	return rv.allowed
+}
+
+// RetryAfter returns how long the caller should wait before the event
+// would be admitted. It is zero when Allowed returns true.
+func (rv *Reservation) RetryAfter() time.Duration {
+	if rv.allowed {
+		return 0
+	}
+	return rv.delay
+}
+
+// NewFailRate creates a failure-only rate limiter that limits globally
+// at 'g' requests/sec and per-host at 'p' requests/sec (same burst
+// convention as New: global burst is 3*g, per-host burst is 2*p). It
+// remembers the 'cachesize' most recently seen hosts. A key's rate is
+// halved after 'threshold' consecutive failures and recovers to its
+// configured rate after 'quiet' elapses without a failure.
+func NewFailRate(g, p, cachesize, threshold int, quiet time.Duration) (*LimiterFailRate, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("ratelimit: failrate needs a positive threshold (saw %d)", threshold)
+	}
+
+	h, err := lru.New2Q[string, *failState](cachesize)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: can't create LRU cache: %s", err)
+	}
+
+	b := 2 * p
+	if b < 0 {
+		b = 0
+	}
+
+	gl := limit(g)
+	pl := limit(p)
+
+	fr := &LimiterFailRate{
+		g:         newFailState(gl, 3*g),
+		h:         h,
+		rate:      gl,
+		hrate:     pl,
+		burst:     3 * g,
+		hburst:    b,
+		threshold: threshold,
+		quiet:     quiet,
+		cache:     cachesize,
+	}
+
+	return fr, nil
+}
+
+func newFailState(r rate.Limit, b int) *failState {
+	return &failState{
+		rl:   rate.NewLimiter(r, b),
+		base: r,
+	}
+}
+
+// Reserve optimistically takes one token from the global bucket and
+// returns a Reservation that must be settled with Success() or
+// Failure().
+func (fr *LimiterFailRate) Reserve() *Reservation {
+	return fr.reserve(fr.g)
+}
+
+// ReserveHost is like Reserve, but for the per-host bucket belonging to
+// host 'a'.
+func (fr *LimiterFailRate) ReserveHost(a net.Addr) *Reservation {
+	return fr.reserve(fr.getState(a))
+}
+
+func (fr *LimiterFailRate) reserve(fs *failState) *Reservation {
+	fs.mu.Lock()
+	if fr.quiet > 0 && fs.consecutive > 0 && time.Since(fs.last) >= fr.quiet {
+		fs.consecutive = 0
+		fs.rl.SetLimit(fs.base)
+	}
+	fs.mu.Unlock()
+
+	now := time.Now()
+	res := fs.rl.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+
+	return &Reservation{
+		res:     res,
+		at:      now,
+		fs:      fs,
+		fr:      fr,
+		allowed: res.OK() && delay <= 0,
+		delay:   delay,
+	}
+}
+
+// Success settles the reservation as a successful operation: the
+// reserved token is returned to the bucket and the key's consecutive
+// failure count is left untouched, so legitimate traffic is free.
+func (rv *Reservation) Success() {
+	rv.res.CancelAt(rv.at)
+}
+
+// Failure settles the reservation as a failed operation: the reserved
+// token is kept (charged), and the key's consecutive failure count is
+// incremented. Once that count reaches the configured threshold (and
+// every multiple thereof), the key's rate is halved.
+func (rv *Reservation) Failure() {
+	fs := rv.fs
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.consecutive++
+	fs.last = time.Now()
+
+	if fs.consecutive%rv.fr.threshold == 0 {
+		if cur := fs.rl.Limit(); cur > 0 && cur != rate.Inf {
+			next := cur / 2
+			if next < 1 {
+				next = 1
+			}
+			fs.rl.SetLimit(next)
+		}
+	}
+}
+
+// get or create the per-host fail-state for 'a'. This evicts the
+// least-recently-used entry from the LRU cache.
+func (fr *LimiterFailRate) getState(a net.Addr) *failState {
+	k := host(a)
+	fs, ok := fr.h.Get(k)
+	if !ok {
+		fs = newFailState(fr.hrate, fr.hburst)
+		fr.h.Add(k, fs)
+	}
+	return fs
+}
+
+// EOF
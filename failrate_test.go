@@ -0,0 +1,117 @@
+// failrate_test.go -- Test harness for LimiterFailRate
+//
+// License: GPLv2
+//
+
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+var failRateTestAddr = &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+
+func TestFailRateSuccessIsFree(t *testing.T) {
+	fr, err := NewFailRate(1000, 2, 16, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	// Burst for the global bucket is 3*1000; repeated successes should
+	// never exhaust it since each one returns its token.
+	for i := 0; i < 4000; i++ {
+		rv := fr.Reserve()
+		if !rv.Allowed() {
+			t.Fatalf("reservation %d: expected successes to never exhaust the burst", i)
+		}
+		rv.Success()
+	}
+}
+
+func TestFailRateBackoff(t *testing.T) {
+	fr, err := NewFailRate(1000, 2, 16, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	rv := fr.ReserveHost(failRateTestAddr)
+	before := fr.getState(failRateTestAddr).rl.Limit()
+
+	rv.Failure()
+
+	rv = fr.ReserveHost(failRateTestAddr)
+	rv.Failure()
+
+	after := fr.getState(failRateTestAddr).rl.Limit()
+	if after != before/2 {
+		t.Fatalf("expected rate to halve after threshold failures: before %v after %v", before, after)
+	}
+}
+
+func TestFailRateDeniesAfterBackoff(t *testing.T) {
+	fr, err := NewFailRate(1000, 4, 16, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	// Drive the per-host rate down towards the floor with repeated
+	// failures, then drain the burst; a subsequent reservation must
+	// report itself denied with a positive retry delay, not just an
+	// internally halved rl.Limit().
+	denied := false
+	for i := 0; i < 20 && !denied; i++ {
+		rv := fr.ReserveHost(failRateTestAddr)
+		if !rv.Allowed() {
+			denied = true
+			if rv.RetryAfter() <= 0 {
+				t.Fatalf("expected a positive retry delay when denied")
+			}
+		}
+		rv.Failure()
+	}
+
+	if !denied {
+		t.Fatalf("expected repeated failures to eventually be denied")
+	}
+}
+
+func TestFailRateBackoffFloor(t *testing.T) {
+	fr, err := NewFailRate(1000, 2, 16, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		rv := fr.ReserveHost(failRateTestAddr)
+		rv.Failure()
+	}
+
+	if got := fr.getState(failRateTestAddr).rl.Limit(); got < 1 {
+		t.Fatalf("expected backoff to floor at 1 event/sec; saw %v", got)
+	}
+}
+
+func TestFailRateRecovery(t *testing.T) {
+	fr, err := NewFailRate(1000, 2, 16, 1, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	rv := fr.ReserveHost(failRateTestAddr)
+	rv.Failure()
+
+	halved := fr.getState(failRateTestAddr).rl.Limit()
+
+	time.Sleep(20 * time.Millisecond)
+
+	fr.ReserveHost(failRateTestAddr)
+
+	recovered := fr.getState(failRateTestAddr).rl.Limit()
+	if recovered == halved {
+		t.Fatalf("expected rate to recover after quiet period")
+	}
+}
+
+// vim: noexpandtab:ts=8:sw=8:tw=92:
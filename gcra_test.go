@@ -0,0 +1,88 @@
+// gcra_test.go -- Test harness for GCRA
+//
+// License: GPLv2
+//
+
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGCRABurst(t *testing.T) {
+	// 5 events/sec, burst of 3
+	g, err := NewGCRA(5, 3, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	if !g.Allow() {
+		t.Fatalf("expected 1st event to be allowed")
+	}
+	if !g.Allow() {
+		t.Fatalf("expected 2nd event to be allowed")
+	}
+	if !g.Allow() {
+		t.Fatalf("expected 3rd event to be allowed")
+	}
+	if g.Allow() {
+		t.Fatalf("expected 4th event to be denied")
+	}
+}
+
+func TestGCRAUnlimited(t *testing.T) {
+	g, err := NewGCRA(0, 1, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if !g.Allow() {
+			t.Fatalf("expected event %d to be allowed", i)
+		}
+	}
+}
+
+func TestGCRAPerHost(t *testing.T) {
+	g, err := NewGCRA(5, 1, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	a := &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+	b := &net.IPAddr{IP: net.ParseIP("127.0.0.2")}
+
+	if !g.AllowHost(a) {
+		t.Fatalf("expected host a to be allowed")
+	}
+	if g.AllowHost(a) {
+		t.Fatalf("expected host a to be denied on 2nd try")
+	}
+	if !g.AllowHost(b) {
+		t.Fatalf("expected host b to be allowed independently of a")
+	}
+}
+
+func TestGCRAWait(t *testing.T) {
+	// 100 events/sec, burst of 1
+	g, err := NewGCRA(100, 1, 16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	if !g.Allow() {
+		t.Fatalf("expected 1st event to be allowed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := g.Wait(ctx); err != nil {
+		t.Fatalf("expected Wait to succeed; saw %s", err)
+	}
+}
+
+// vim: noexpandtab:ts=8:sw=8:tw=92:
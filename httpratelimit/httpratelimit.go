@@ -0,0 +1,179 @@
+// httpratelimit.go - net/http middleware wrapping ratelimit.Limiter
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+// Package httpratelimit provides net/http middleware that enforces a
+// per-client rate limit using ratelimit.Limiter and reports the limiter
+// state back to the caller via the conventional X-RateLimit-* and
+// Retry-After response headers.
+//
+// Usage:
+//
+//	rl, _ := ratelimit.New(1000, 5, 30000)
+//	mw, _ := httpratelimit.New(rl, "10.0.0.0/8")
+//	http.Handle("/", mw.Handler(myHandler))
+package httpratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opencoff/go-ratelimit"
+)
+
+// Middleware rate-limits incoming HTTP requests by client IP, using an
+// underlying ratelimit.Limiter for the per-host token bucket.
+type Middleware struct {
+	rl      *ratelimit.Limiter
+	trusted []*net.IPNet
+}
+
+// New creates a Middleware that enforces 'rl' on every request. 'trusted'
+// is an optional list of proxy IPs or CIDRs; when the immediate peer
+// ("RemoteAddr") matches one of them, the middleware trusts the
+// X-Forwarded-For or X-Real-IP header (in that order) to determine the
+// real client IP instead.
+func New(rl *ratelimit.Limiter, trusted ...string) (*Middleware, error) {
+	var nets []*net.IPNet
+
+	for _, p := range trusted {
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, fmt.Errorf("httpratelimit: invalid trusted proxy %q", p)
+		}
+
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	m := &Middleware{
+		rl:      rl,
+		trusted: nets,
+	}
+	return m, nil
+}
+
+// Handler wraps 'next' with the rate-limit check. Requests that exceed
+// the per-host limit are rejected with 429 Too Many Requests; all
+// responses (allowed or not) carry the X-RateLimit-* headers describing
+// the current state of the client's bucket.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := &net.IPAddr{IP: net.ParseIP(m.clientIP(r))}
+
+		if !m.rl.AllowHost(addr) {
+			m.setHeaders(w, addr)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter(m.rl, addr)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		m.setHeaders(w, addr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setHeaders populates the standard X-RateLimit-* response headers from
+// the current (unconsumed) state of the per-host bucket for 'addr'.
+func (m *Middleware) setHeaders(w http.ResponseWriter, addr net.Addr) {
+	burst := m.rl.BurstHost(addr)
+	tokens := m.rl.TokensHost(addr)
+
+	remaining := int(math.Floor(tokens))
+	if remaining < 0 {
+		remaining = 0
+	}
+	if remaining > burst {
+		remaining = burst
+	}
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds(m.rl, addr)))
+}
+
+// resetSeconds returns the number of whole seconds until the per-host
+// bucket for 'addr' refills to its configured burst size.
+func resetSeconds(rl *ratelimit.Limiter, addr net.Addr) int {
+	limit := rl.LimitHost(addr)
+	if limit <= 0 {
+		return 0
+	}
+
+	burst := float64(rl.BurstHost(addr))
+	tokens := rl.TokensHost(addr)
+	need := burst - tokens
+	if need <= 0 {
+		return 0
+	}
+	return int(math.Ceil(need / float64(limit)))
+}
+
+// retryAfter returns the number of whole seconds until at least one
+// token is available in the per-host bucket for 'addr'.
+func retryAfter(rl *ratelimit.Limiter, addr net.Addr) int {
+	limit := rl.LimitHost(addr)
+	if limit <= 0 {
+		return 0
+	}
+
+	tokens := rl.TokensHost(addr)
+	need := 1 - tokens
+	if need <= 0 {
+		return 0
+	}
+	return int(math.Ceil(need / float64(limit)))
+}
+
+// clientIP determines the client IP for 'r', honoring X-Forwarded-For
+// and X-Real-IP only when the immediate peer is a configured trusted
+// proxy.
+func (m *Middleware) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip != nil && m.isTrusted(ip) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.SplitN(xff, ",", 2)
+			return strings.TrimSpace(parts[0])
+		}
+		if xr := r.Header.Get("X-Real-IP"); xr != "" {
+			return strings.TrimSpace(xr)
+		}
+	}
+
+	return host
+}
+
+// isTrusted returns true if 'ip' falls within one of the configured
+// trusted-proxy networks.
+func (m *Middleware) isTrusted(ip net.IP) bool {
+	for _, n := range m.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -30,23 +30,46 @@ package ratelimit
 import (
 	"context"
 	"fmt"
-	"github.com/hashicorp/golang-lru/v2"
 	"golang.org/x/time/rate"
 	"net"
+	"time"
 )
 
+// RateLimiter is the common interface implemented by every rate limiter
+// in this package (token-bucket Limiter, leaky-bucket GCRA). Code that
+// only needs to gate events globally and per-host can depend on this
+// interface instead of a concrete implementation.
+type RateLimiter interface {
+	// Allow returns true if the global rate limit permits one more event.
+	Allow() bool
+
+	// AllowHost returns true if the per-host rate limit for 'a' permits
+	// one more event.
+	AllowHost(a net.Addr) bool
+
+	// Wait blocks until the global rate limit permits one more event or
+	// 'ctx' is cancelled.
+	Wait(ctx context.Context) error
+
+	// WaitHost blocks until the per-host rate limit for 'a' permits one
+	// more event or 'ctx' is cancelled.
+	WaitHost(ctx context.Context, a net.Addr) error
+}
+
 // Limiter controls how frequently events are allowed to happen globally or
-// per-host. It uses a token-bucket limiter for the global limit and instantiates
-// a token-bucket limiter for every unique host. The number of per-host limiters
-// is limited to an upper bound ("cache size").
+// per-host. It uses a token-bucket limiter for the global limit and a
+// pluggable Store (a per-key token bucket) for the per-host limit. The
+// default Store is an in-process LRU (MemoryStore), bounded to an upper
+// bound ("cache size"); use NewWithStore to share per-host state across
+// instances, e.g. via RedisStore.
 //
 // A negative rate limit means "no limit" and a zero rate limit means "Infinite".
 type Limiter struct {
 	// Global rate limiter; thread-safe
 	gl *rate.Limiter
 
-	// Per-host limiter organized as an LRU cache; thread-safe
-	h *lru.TwoQueueCache[string, *rate.Limiter]
+	// Per-host token-bucket state; thread-safe
+	h Store
 
 	// per host rate limit (qps)
 	p rate.Limit
@@ -63,11 +86,24 @@ type Limiter struct {
 // recent hosts (and their limits). The burst rates are pre-configured to be:
 // Global burst limit: 3 * b; Per host burst limit:  2 * p
 func New(g, p, cachesize int) (*Limiter, error) {
-	l, err := lru.New2Q[string, *rate.Limiter](cachesize)
+	h, err := NewMemoryStore(cachesize)
 	if err != nil {
-		return nil, fmt.Errorf("ratelimit: can't create LRU cache: %s", err)
+		return nil, err
 	}
 
+	r, err := NewWithStore(g, p, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache = cachesize
+	return r, nil
+}
+
+// NewWithStore is like New, but persists per-host state in 'h' instead of
+// the default in-process MemoryStore. Use this to share per-host limits
+// across multiple instances of an application, e.g. with a RedisStore.
+func NewWithStore(g, p int, h Store) (*Limiter, error) {
 	b := 2 * p
 	if b < 0 {
 		b = 0
@@ -77,17 +113,18 @@ func New(g, p, cachesize int) (*Limiter, error) {
 	pl := limit(p)
 
 	r := &Limiter{
-		gl:    rate.NewLimiter(gl, 3*g),
-		h:     l,
-		p:     pl,
-		g:     gl,
-		b:     b,
-		cache: cachesize,
+		gl: rate.NewLimiter(gl, 3*g),
+		h:  h,
+		p:  pl,
+		g:  gl,
+		b:  b,
 	}
 
 	return r, nil
 }
 
+var _ RateLimiter = (*Limiter)(nil)
+
 // Wait blocks until the ratelimiter permits the configured global rate limit.
 // It returns an error if the burst exceeds the configured limit or the
 // context is cancelled.
@@ -100,8 +137,39 @@ func (r *Limiter) Wait(ctx context.Context) error {
 // It returns an error if the burst exceeds the configured limit or the
 // context is cancelled.
 func (r *Limiter) WaitHost(ctx context.Context, a net.Addr) error {
-	rl := r.getRL(a)
-	return rl.Wait(ctx)
+	return r.WaitNHost(ctx, a, 1)
+}
+
+// WaitN blocks until the global rate limit permits 'n' events. It
+// returns an error if 'n' exceeds the configured burst or the context is
+// cancelled.
+func (r *Limiter) WaitN(ctx context.Context, n int) error {
+	return r.gl.WaitN(ctx, n)
+}
+
+// WaitNHost blocks until the per-host rate limit for host 'a' permits
+// 'n' events or 'ctx' is cancelled. It returns an error if 'n' exceeds
+// the configured per-host burst, the same as WaitN.
+func (r *Limiter) WaitNHost(ctx context.Context, a net.Addr, n int) error {
+	if r.p != rate.Inf && n > r.b {
+		return fmt.Errorf("ratelimit: WaitNHost(n=%d) exceeds per-host burst %d", n, r.b)
+	}
+
+	k := host(a)
+	for {
+		ok, wait := r.h.Take(k, n, r.p, r.b)
+		if ok {
+			return nil
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
 }
 
 // Allow returns true if the global rate limit can consume 1 token and
@@ -115,26 +183,73 @@ func (r *Limiter) Allow() bool {
 // 1 token and false otherwise. Use this if you intend to drop/skip events
 // that exceed a configured global rate limit, otherwise, use WaitHost().
 func (r *Limiter) AllowHost(a net.Addr) bool {
-	rl := r.getRL(a)
-	return rl.Allow()
+	k := host(a)
+	ok, _ := r.h.Take(k, 1, r.p, r.b)
+	return ok
 }
 
 // String returns a printable representation of the limiter
 func (r Limiter) String() string {
-	return fmt.Sprintf("ratelimiter: Global %4.2 rps, Per host %4.2 rps, LRU cache %d entries",
+	return fmt.Sprintf("ratelimiter: Global %4.2f rps, Per host %4.2f rps, LRU cache %d entries",
 		r.g, r.p, r.cache)
 }
 
-// get or create a new per-host rate limiter.
-// this function evicts the least used limiter from the LRU cache
-func (r *Limiter) getRL(a net.Addr) *rate.Limiter {
+// Tokens returns the number of tokens currently available in the global
+// bucket without consuming any of them. Callers can use this to peek at
+// the current rate-limit state, e.g. to populate informational headers.
+func (r *Limiter) Tokens() float64 {
+	return r.gl.Tokens()
+}
+
+// TokensHost returns the number of tokens currently available in the
+// per-host bucket for 'a' without consuming any of them.
+func (r *Limiter) TokensHost(a net.Addr) float64 {
 	k := host(a)
-	rl, ok := r.h.Get(k)
-	if !ok {
-		rl = rate.NewLimiter(r.p, r.b)
-		r.h.Add(k, rl)
+	return r.h.Peek(k, r.p, r.b)
+}
+
+// Burst returns the configured global burst size.
+func (r *Limiter) Burst() int {
+	return r.gl.Burst()
+}
+
+// BurstHost returns the configured per-host burst size.
+func (r *Limiter) BurstHost(a net.Addr) int {
+	return r.b
+}
+
+// Limit returns the configured global rate limit in requests/sec.
+func (r *Limiter) Limit() rate.Limit {
+	return r.gl.Limit()
+}
+
+// LimitHost returns the configured per-host rate limit in requests/sec
+// for 'a'.
+func (r *Limiter) LimitHost(a net.Addr) rate.Limit {
+	return r.p
+}
+
+// SetLimit retunes the global rate limit to 'g' requests/sec, adjusting
+// the burst to 3*g (the same ratio New uses).
+func (r *Limiter) SetLimit(g int) {
+	gl := limit(g)
+	r.g = gl
+	r.gl.SetBurst(3 * g)
+	r.gl.SetLimit(gl)
+}
+
+// SetLimitHost retunes the per-host rate limit to 'p' requests/sec,
+// adjusting the burst to 2*p (the same ratio New uses). This affects
+// every host, not just one, since per-host rate/burst are evaluated
+// against the Store on every call rather than stored per key.
+func (r *Limiter) SetLimitHost(p int) {
+	b := 2 * p
+	if b < 0 {
+		b = 0
 	}
-	return rl
+
+	r.p = limit(p)
+	r.b = b
 }
 
 // return the host from the address
@@ -0,0 +1,147 @@
+// redisstore.go - Redis-backed Store for sharing per-host limits
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// tokenBucketScript evaluates and updates a single key's token bucket in
+// one round trip: it reads the stored token count and last-refill time,
+// refills to 'now' at the configured rate (capped at the configured
+// burst) and, unless ARGV[6] ("peek") is set, consumes 'n' tokens when
+// available. It returns {allowed (0/1), wait_ms} for a normal call, or
+// {tokens, 0} for a peek. Callers must special-case rate.Inf themselves;
+// this script assumes a finite rate and burst.
+var tokenBucketScript = redis.NewScript(`
+local key   = KEYS[1]
+local n     = tonumber(ARGV[1])
+local rate  = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local now   = tonumber(ARGV[4])
+local ttl   = tonumber(ARGV[5])
+local peek  = tonumber(ARGV[6])
+
+local tokens = burst
+local last   = now
+
+local state = redis.call("HMGET", key, "tokens", "last")
+if state[1] then
+	tokens = tonumber(state[1])
+	last   = tonumber(state[2])
+end
+
+if rate > 0 then
+	tokens = math.min(burst, tokens + (now - last) / 1000.0 * rate)
+end
+
+if peek == 1 then
+	return {tostring(tokens), 0}
+end
+
+local allowed = 0
+local wait_ms = 0
+
+if tokens >= n then
+	allowed = 1
+	tokens = tokens - n
+elseif rate > 0 then
+	wait_ms = math.ceil((n - tokens) / rate * 1000)
+end
+
+redis.call("HSET", key, "tokens", tostring(tokens), "last", now)
+if ttl > 0 then
+	redis.call("PEXPIRE", key, ttl)
+end
+
+return {allowed, wait_ms}
+`)
+
+// RedisStore is a Store backed by Redis, so that a single client IP
+// hitting many backend instances draws from one shared per-host budget.
+// Each Take/Peek is a single round trip: the read-refill-write sequence
+// runs atomically inside Redis via tokenBucketScript, so no client-side
+// locking is needed.
+type RedisStore struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// NewRedisStore creates a RedisStore that persists state via 'rdb'. Idle
+// keys are expired after 'ttl'; use 0 to keep them forever.
+func NewRedisStore(rdb *redis.Client, ttl time.Duration) *RedisStore {
+	return &RedisStore{rdb: rdb, ttl: ttl}
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// Take implements Store. If Redis is unreachable, Take fails open
+// (allows the event) rather than mistaking a store outage for a client
+// exceeding its budget.
+func (s *RedisStore) Take(key string, n int, r rate.Limit, b int) (bool, time.Duration) {
+	if r == rate.Inf {
+		return true, 0
+	}
+
+	res, err := s.eval(key, n, r, b, false)
+	if err != nil {
+		return true, 0
+	}
+
+	allowed, _ := res[0].(int64)
+	waitMs, _ := res[1].(int64)
+	return allowed == 1, time.Duration(waitMs) * time.Millisecond
+}
+
+// Peek implements Store. If Redis is unreachable, Peek reports a full
+// bucket.
+func (s *RedisStore) Peek(key string, r rate.Limit, b int) float64 {
+	if r == rate.Inf {
+		return math.Inf(1)
+	}
+
+	res, err := s.eval(key, 1, r, b, true)
+	if err != nil {
+		return float64(b)
+	}
+
+	tokens, _ := strconv.ParseFloat(res[0].(string), 64)
+	return tokens
+}
+
+// TTL implements Store.
+func (s *RedisStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *RedisStore) eval(key string, n int, r rate.Limit, b int, peek bool) ([]interface{}, error) {
+	now := time.Now().UnixMilli()
+
+	peekArg := 0
+	if peek {
+		peekArg = 1
+	}
+
+	res, err := tokenBucketScript.Run(context.Background(), s.rdb, []string{key},
+		n, float64(r), b, now, s.ttl.Milliseconds(), peekArg).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]interface{}), nil
+}
+
+// EOF
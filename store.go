@@ -0,0 +1,138 @@
+// store.go - Pluggable storage backend for per-host limiter state
+//
+// License: GPLv2
+//
+// This software does not come with any express or implied
+// warranty; it is provided "as is". No claim  is made to its
+// suitability for any purpose.
+//
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// HostState is the token-bucket state persisted for a single per-host
+// key: the token count as of 'Last', the time it was last updated.
+type HostState struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// Store persists per-key token-bucket state for Limiter's per-host
+// limits. MemoryStore keeps this state in an in-process LRU; RedisStore
+// shares it across instances via Redis, so that a single client IP
+// hitting multiple backends still draws from one global per-host budget.
+type Store interface {
+	// Take evaluates the token bucket for 'key' at rate 'r' events/sec
+	// with burst 'b' as of now, atomically consuming 'n' tokens if
+	// available. It returns whether the event was admitted and, when it
+	// wasn't, how long the caller should wait before retrying. 'r' ==
+	// rate.Inf means unlimited, and Take must always admit regardless of
+	// 'b' (which may be 0 for an unlimited rate derived from a negative
+	// per-host limit).
+	Take(key string, n int, r rate.Limit, b int) (bool, time.Duration)
+
+	// Peek returns the current token count for 'key', projected forward
+	// to now at rate 'r' with burst 'b'. It does not consume a token or
+	// otherwise mutate the stored state.
+	Peek(key string, r rate.Limit, b int) float64
+
+	// TTL returns how long an idle key's state is retained before it is
+	// evicted or expired. A zero value means the Store relies on some
+	// other bound (e.g. MemoryStore's LRU size) instead of a TTL.
+	TTL() time.Duration
+}
+
+// refill projects 'st' forward to 'now' at rate 'r' with burst 'b',
+// returning the resulting token count. 'ok' is false when there is no
+// prior state, in which case the bucket starts full. Callers must
+// special-case r == rate.Inf themselves; refill assumes a finite rate.
+func refill(st HostState, ok bool, now time.Time, r rate.Limit, b int) float64 {
+	if !ok {
+		return float64(b)
+	}
+
+	tokens := st.Tokens + now.Sub(st.Last).Seconds()*float64(r)
+	if tokens > float64(b) {
+		tokens = float64(b)
+	}
+	return tokens
+}
+
+// MemoryStore is the default in-process Store. It keeps per-key state in
+// an LRU cache bounded to a maximum number of entries, evicting the
+// least-recently-used key once that bound is reached.
+type MemoryStore struct {
+	mu    sync.Mutex
+	cache *lru.TwoQueueCache[string, HostState]
+}
+
+// NewMemoryStore creates a MemoryStore that remembers the state of the
+// 'cachesize' most recently seen keys.
+func NewMemoryStore(cachesize int) (*MemoryStore, error) {
+	c, err := lru.New2Q[string, HostState](cachesize)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: can't create LRU cache: %s", err)
+	}
+
+	return &MemoryStore{cache: c}, nil
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// Take implements Store.
+func (m *MemoryStore) Take(key string, n int, r rate.Limit, b int) (bool, time.Duration) {
+	if r == rate.Inf {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.cache.Get(key)
+	tokens := refill(st, ok, now, r, b)
+
+	if tokens < float64(n) {
+		m.cache.Add(key, HostState{Tokens: tokens, Last: now})
+
+		var wait time.Duration
+		if r > 0 {
+			wait = time.Duration((float64(n) - tokens) / float64(r) * float64(time.Second))
+		}
+		return false, wait
+	}
+
+	m.cache.Add(key, HostState{Tokens: tokens - float64(n), Last: now})
+	return true, 0
+}
+
+// Peek implements Store.
+func (m *MemoryStore) Peek(key string, r rate.Limit, b int) float64 {
+	if r == rate.Inf {
+		return math.Inf(1)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.cache.Get(key)
+	return refill(st, ok, time.Now(), r, b)
+}
+
+// TTL implements Store. MemoryStore has no TTL; idle keys are instead
+// bounded by the LRU's cache size.
+func (m *MemoryStore) TTL() time.Duration {
+	return 0
+}
+
+// EOF
@@ -0,0 +1,33 @@
+// store_test.go -- Test harness for MemoryStore
+//
+// License: GPLv2
+//
+
+package ratelimit
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestMemoryStoreUnlimitedRate(t *testing.T) {
+	m, err := NewMemoryStore(16)
+	if err != nil {
+		t.Fatalf("expected err to be nil; saw %s", err)
+	}
+
+	// A negative per-host rate maps to rate.Inf with a burst of 0 (2*p
+	// clamped); Take must still admit every call instead of treating the
+	// clamped burst as "no tokens".
+	for i := 0; i < 10; i++ {
+		ok, _ := m.Take("1.2.3.4", 1, rate.Inf, 0)
+		if !ok {
+			t.Fatalf("call %d: expected unlimited rate to always be allowed", i)
+		}
+	}
+
+	if got := m.Peek("1.2.3.4", rate.Inf, 0); got <= 0 {
+		t.Fatalf("expected Peek to report unlimited tokens for an unlimited rate; saw %v", got)
+	}
+}